@@ -1,80 +1,120 @@
 package airbrake // import "gopkg.in/gemnasium/logrus-airbrake-hook.v3"
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"net/http"
 	"os"
+	"time"
 
+	"github.com/CIP-NL/logrus-hooks/errortracker"
 	"github.com/airbrake/gobrake"
 	"github.com/sirupsen/logrus"
 )
 
-// AirbrakeHook to send exceptions to an exception-tracking service compatible
-// with the Airbrake API.
-type airbrakeHook struct {
-	Airbrake *gobrake.Notifier
+// backend adapts a *gobrake.Notifier to errortracker.Backend.
+type backend struct {
+	notifier *gobrake.Notifier
 }
 
-// NewHook Returns a new Airbrake hook given the projectID, apiKey and environment
-func NewHook(projectID int64, apiKey, env string) *airbrakeHook {
-	airbrake := gobrake.NewNotifier(projectID, apiKey)
-	airbrake.AddFilter(func(notice *gobrake.Notice) *gobrake.Notice {
-		if env == "development" {
-			return nil
-		}
-		notice.Context["environment"] = env
-		return notice
-	})
-	hook := &airbrakeHook{
-		Airbrake: airbrake,
+// Notify builds a gobrake.Notice from event and sends it to Airbrake.
+func (b *backend) Notify(ctx context.Context, event errortracker.Event) error {
+	notifyErr := event.Err
+	if notifyErr == nil {
+		notifyErr = errors.New(event.Message)
 	}
-	return hook
-}
 
-func (hook *airbrakeHook) Fire(entry *logrus.Entry) error {
-	var notifyErr error
-	err, ok := entry.Data["error"].(error)
-	if ok {
-		notifyErr = err
-	} else {
-		notifyErr = errors.New(entry.Message)
+	notice := b.notifier.Notice(notifyErr, event.Request, 3)
+	if len(event.Stacktrace) > 0 && len(notice.Errors) > 0 {
+		notice.Errors[0].Backtrace = toBacktrace(event.Stacktrace)
 	}
-	var req *http.Request
-	for k, v := range entry.Data {
-		if r, ok := v.(*http.Request); ok {
-			req = r
-			delete(entry.Data, k)
-			break
-		}
+
+	for k, v := range event.Extras {
+		notice.Context[k] = fmt.Sprintf("%v", v)
+	}
+	for k, v := range event.Tags {
+		notice.Context[k] = v
 	}
-	notice := hook.Airbrake.Notice(notifyErr, req, 3)
-	for k, v := range entry.Data {
-		notice.Context[k] = fmt.Sprintf("%s", v)
+	for k, v := range event.User {
+		notice.Context["user_"+k] = fmt.Sprintf("%v", v)
 	}
+	notice.Context["severity"] = event.Level.String()
 
-	hook.sendNotice(notice)
+	if _, err := b.notifier.SendNotice(notice); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to send error to Airbrake: %v\n", err)
+		return err
+	}
 	return nil
 }
 
-func (hook *airbrakeHook) sendNotice(notice *gobrake.Notice) {
-	if _, err := hook.Airbrake.SendNotice(notice); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to send error to Airbrake: %v\n", err)
+// Flush flushes the underlying notifier's delivery queue, waiting up to
+// timeout for it to drain.
+func (b *backend) Flush(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		b.notifier.Flush()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("no response from airbrake in %s", timeout)
 	}
 }
 
-func (hook *airbrakeHook) Levels() []logrus.Level {
-	return []logrus.Level{
+func toBacktrace(frames []errortracker.Frame) []gobrake.StackFrame {
+	backtrace := make([]gobrake.StackFrame, len(frames))
+	for i, frame := range frames {
+		backtrace[i] = gobrake.StackFrame{
+			File: frame.File,
+			Line: frame.Line,
+			Func: frame.Function,
+		}
+	}
+	return backtrace
+}
+
+// NewHook Returns a new Airbrake hook given the projectID, apiKey and environment
+func NewHook(projectID int64, apiKey, env string) *errortracker.Hook {
+	notifier := gobrake.NewNotifier(projectID, apiKey)
+	notifier.AddFilter(func(notice *gobrake.Notice) *gobrake.Notice {
+		if env == "development" {
+			return nil
+		}
+		notice.Context["environment"] = env
+		return notice
+	})
+	return NewHookWithNotifier(notifier, []logrus.Level{
 		logrus.ErrorLevel,
 		logrus.FatalLevel,
 		logrus.PanicLevel,
-	}
+	})
+}
+
+// NewHookWithNotifier returns a hook built on an already configured
+// *gobrake.Notifier, so callers can set up proxy/TLS/host on the notifier
+// themselves before handing it to the hook.
+func NewHookWithNotifier(notifier *gobrake.Notifier, levels []logrus.Level) *errortracker.Hook {
+	return errortracker.NewHook(&backend{notifier: notifier}, levels, &errortracker.Options{
+		StacktraceConfiguration: errortracker.StacktraceConfiguration{
+			Enable: true,
+			Level:  logrus.ErrorLevel,
+		},
+	})
 }
 
-// LogAttempt used to test error messages
-// func LogAttempt(projectID int64, testAPIKey string, testEnv string) {
-// 	log := logrus.New()
-// 	log.Level = logrus.DebugLevel
-// 	log.AddHook(NewHook(projectID, testAPIKey, testEnv))
-// 	log.Error("Bitcoin price: 0")
-// }
\ No newline at end of file
+// NewAsyncHookWithNotifier is the asynchronous counterpart of
+// NewHookWithNotifier: Fire returns immediately and delivery errors are not
+// returned to the caller (the backend prints them to stderr). Flush waits
+// for deliveries in flight and then flushes the notifier.
+func NewAsyncHookWithNotifier(notifier *gobrake.Notifier, levels []logrus.Level) *errortracker.Hook {
+	return errortracker.NewHook(&backend{notifier: notifier}, levels, &errortracker.Options{
+		Async: true,
+		StacktraceConfiguration: errortracker.StacktraceConfiguration{
+			Enable: true,
+			Level:  logrus.ErrorLevel,
+		},
+	})
+}
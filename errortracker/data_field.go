@@ -0,0 +1,100 @@
+package errortracker
+
+import "net/http"
+
+// Special keys in a logrus.Entry's Data that carry tracker-specific meaning
+// instead of being reported as plain extra data.
+const (
+	fieldKeyError       = "error"
+	fieldKeyHTTPRequest = "http_request"
+	fieldKeyUser        = "user"
+	fieldKeyTags        = "tags"
+	fieldKeyFingerprint = "fingerprint"
+	fieldKeyLogger      = "logger"
+	fieldKeyServerName  = "server_name"
+	fieldKeyEventID     = "event_id"
+)
+
+// DataField wraps a logrus.Entry's Data and knows how to pull the special
+// fields out of it, leaving the rest to be reported as extra data. It is
+// exported so that Backend-specific hooks (e.g. sentry.Hook, which layers
+// its own extraction on top for breadcrumbs/sampling) can share this logic
+// instead of re-implementing it.
+type DataField struct {
+	data map[string]interface{}
+}
+
+// NewDataField wraps data for extraction.
+func NewDataField(data map[string]interface{}) *DataField {
+	return &DataField{data: data}
+}
+
+// Data returns the wrapped fields, unfiltered.
+func (df *DataField) Data() map[string]interface{} {
+	return df.data
+}
+
+// Len returns the number of wrapped fields.
+func (df *DataField) Len() int {
+	return len(df.data)
+}
+
+// IsOmit reports whether key is one of the special fields extracted
+// separately, and so should not also be copied into extra data.
+func (df *DataField) IsOmit(key string) bool {
+	switch key {
+	case fieldKeyError, fieldKeyHTTPRequest, fieldKeyUser, fieldKeyTags, fieldKeyFingerprint,
+		fieldKeyLogger, fieldKeyServerName, fieldKeyEventID:
+		return true
+	default:
+		return false
+	}
+}
+
+// Error returns the error field, if any.
+func (df *DataField) Error() (error, bool) {
+	err, ok := df.data[fieldKeyError].(error)
+	return err, ok
+}
+
+// HTTPRequest returns the http_request field, if any.
+func (df *DataField) HTTPRequest() (*http.Request, bool) {
+	req, ok := df.data[fieldKeyHTTPRequest].(*http.Request)
+	return req, ok
+}
+
+// User returns the user field, if any.
+func (df *DataField) User() (map[string]interface{}, bool) {
+	user, ok := df.data[fieldKeyUser].(map[string]interface{})
+	return user, ok
+}
+
+// Tags returns the tags field, if any.
+func (df *DataField) Tags() (map[string]string, bool) {
+	tags, ok := df.data[fieldKeyTags].(map[string]string)
+	return tags, ok
+}
+
+// Fingerprint returns the fingerprint field, if any.
+func (df *DataField) Fingerprint() ([]string, bool) {
+	fingerprint, ok := df.data[fieldKeyFingerprint].([]string)
+	return fingerprint, ok
+}
+
+// Logger returns the logger field, if any.
+func (df *DataField) Logger() (string, bool) {
+	logger, ok := df.data[fieldKeyLogger].(string)
+	return logger, ok
+}
+
+// ServerName returns the server_name field, if any.
+func (df *DataField) ServerName() (string, bool) {
+	serverName, ok := df.data[fieldKeyServerName].(string)
+	return serverName, ok
+}
+
+// EventID returns the event_id field, if any.
+func (df *DataField) EventID() (string, bool) {
+	eventID, ok := df.data[fieldKeyEventID].(string)
+	return eventID, ok
+}
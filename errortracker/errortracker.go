@@ -0,0 +1,231 @@
+// Package errortracker provides a logrus hook that extracts the fields
+// shared by most exception-tracking services (an error, an *http.Request,
+// a user, tags, a fingerprint, extra context) and hands them to a pluggable
+// Backend. The airbrake hook is a thin Backend implementation built on top
+// of this package; adding support for a new service (Bugsnag, Rollbar,
+// GlitchTip, ...) is a matter of implementing Backend rather than re-writing
+// field extraction and async delivery again. sentry.Hook does not implement
+// Backend: it keeps its own Fire and delivery, and reuses only BuildEvent
+// for field extraction.
+package errortracker
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Event is the normalized representation of a logrus.Entry that Backend
+// implementations translate into their own wire format.
+type Event struct {
+	Level       logrus.Level
+	Message     string
+	Time        time.Time
+	Err         error
+	Request     *http.Request
+	User        map[string]interface{}
+	Tags        map[string]string
+	Fingerprint []string
+	Logger      string
+	ServerName  string
+	EventID     string
+	Extras      map[string]interface{}
+	Stacktrace  []Frame
+	Breadcrumbs []Breadcrumb
+}
+
+// Frame is a single, backend-agnostic stack frame.
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// Breadcrumb is a backend-agnostic breadcrumb, for backends that support
+// attaching trailing context to a captured event.
+type Breadcrumb struct {
+	Time     time.Time
+	Level    logrus.Level
+	Category string
+	Message  string
+	Data     map[string]interface{}
+}
+
+// Backend delivers a normalized Event to a specific exception-tracking
+// service.
+type Backend interface {
+	// Notify delivers event, returning an error if delivery failed.
+	Notify(ctx context.Context, event Event) error
+	// Flush blocks until any events queued by a prior Notify have been
+	// delivered, or timeout elapses.
+	Flush(timeout time.Duration) error
+}
+
+// StacktraceConfiguration controls whether and how Hook extracts a
+// stacktrace from the error field of a captured entry.
+type StacktraceConfiguration struct {
+	// whether stacktraces should be extracted at all
+	Enable bool
+	// the level at which to start extracting stacktraces
+	Level logrus.Level
+}
+
+// Options configures a Hook. The zero value is valid and disables
+// stacktrace extraction and asynchronous delivery.
+type Options struct {
+	Async                   bool
+	StacktraceConfiguration StacktraceConfiguration
+}
+
+// Hook is a logrus.Hook that extracts the fields common to exception
+// trackers from an entry and forwards the result to a Backend.
+type Hook struct {
+	Timeout                 time.Duration
+	StacktraceConfiguration StacktraceConfiguration
+
+	backend Backend
+	levels  []logrus.Level
+
+	ignoreFields map[string]struct{}
+	extraFilters map[string]func(interface{}) interface{}
+
+	asynchronous bool
+
+	mu sync.RWMutex
+	wg sync.WaitGroup
+}
+
+// NewHook creates a Hook that delivers to backend for the given levels.
+// opts may be nil to accept the defaults (synchronous delivery, no
+// stacktrace extraction).
+func NewHook(backend Backend, levels []logrus.Level, opts *Options) *Hook {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &Hook{
+		Timeout:                 100 * time.Millisecond,
+		StacktraceConfiguration: opts.StacktraceConfiguration,
+		backend:                 backend,
+		levels:                  levels,
+		ignoreFields:            make(map[string]struct{}),
+		extraFilters:            make(map[string]func(interface{}) interface{}),
+		asynchronous:            opts.Async,
+	}
+}
+
+// Fire extracts the special fields from entry.Data and delivers the
+// resulting Event to the Backend.
+func (hook *Hook) Fire(entry *logrus.Entry) error {
+	hook.mu.RLock()
+	defer hook.mu.RUnlock()
+
+	event := hook.buildEvent(entry)
+
+	if hook.asynchronous {
+		hook.wg.Add(1)
+		go func() {
+			defer hook.wg.Done()
+			hook.backend.Notify(context.Background(), event)
+		}()
+		return nil
+	}
+
+	return hook.backend.Notify(context.Background(), event)
+}
+
+func (hook *Hook) buildEvent(entry *logrus.Entry) Event {
+	return BuildEvent(entry, hook.StacktraceConfiguration, hook.ignoreFields, hook.extraFilters)
+}
+
+// BuildEvent extracts the fields errortracker understands from entry into a
+// normalized Event, applying ignoreFields/extraFilters to whatever is left
+// over as Event.Extras. It is exported so that a Backend-specific hook with
+// its own additional features (e.g. sentry.Hook's breadcrumbs and sampling)
+// can reuse the same extraction instead of duplicating it.
+func BuildEvent(entry *logrus.Entry, stConfig StacktraceConfiguration, ignoreFields map[string]struct{}, extraFilters map[string]func(interface{}) interface{}) Event {
+	df := NewDataField(entry.Data)
+
+	event := Event{
+		Level:   entry.Level,
+		Message: entry.Message,
+		Time:    entry.Time,
+		Extras:  formatExtraData(df, ignoreFields, extraFilters),
+	}
+
+	if err, ok := df.Error(); ok {
+		event.Err = err
+		if stConfig.Enable && entry.Level <= stConfig.Level {
+			event.Stacktrace = extractStacktrace(err)
+		}
+	}
+	if req, ok := df.HTTPRequest(); ok {
+		event.Request = req
+	}
+	if user, ok := df.User(); ok {
+		event.User = user
+	}
+	if tags, ok := df.Tags(); ok {
+		event.Tags = tags
+	}
+	if fingerprint, ok := df.Fingerprint(); ok {
+		event.Fingerprint = fingerprint
+	}
+	if logger, ok := df.Logger(); ok {
+		event.Logger = logger
+	}
+	if serverName, ok := df.ServerName(); ok {
+		event.ServerName = serverName
+	}
+	if eventID, ok := df.EventID(); ok {
+		event.EventID = eventID
+	}
+
+	return event
+}
+
+// Levels returns the available logging levels.
+func (hook *Hook) Levels() []logrus.Level {
+	return hook.levels
+}
+
+// AddIgnore adds field name to ignore when copying extra data.
+func (hook *Hook) AddIgnore(name string) {
+	hook.ignoreFields[name] = struct{}{}
+}
+
+// AddExtraFilter adds a custom filter function for field name.
+func (hook *Hook) AddExtraFilter(name string, fn func(interface{}) interface{}) {
+	hook.extraFilters[name] = fn
+}
+
+// Flush waits for any asynchronous deliveries in flight and then flushes
+// the backend. Flush always consults the Backend, even for synchronous
+// hooks, since the Backend itself may batch deliveries.
+func (hook *Hook) Flush() error {
+	if hook.asynchronous {
+		hook.mu.Lock()
+		hook.wg.Wait()
+		hook.mu.Unlock()
+	}
+	return hook.backend.Flush(hook.Timeout)
+}
+
+func formatExtraData(df *DataField, ignoreFields map[string]struct{}, extraFilters map[string]func(interface{}) interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, df.Len())
+	for k, v := range df.Data() {
+		if df.IsOmit(k) {
+			continue
+		}
+		if _, ok := ignoreFields[k]; ok {
+			continue
+		}
+		if fn, ok := extraFilters[k]; ok {
+			v = fn(v)
+		}
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,52 @@
+package errortracker
+
+import (
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+type causer interface {
+	Cause() error
+}
+
+type pkgErrorStackTracer interface {
+	StackTrace() errors.StackTrace
+}
+
+// extractStacktrace walks err's cause chain looking for the earliest
+// github.com/pkg/errors stack trace, and converts it to a backend-agnostic
+// []Frame, oldest frame first.
+func extractStacktrace(err error) []Frame {
+	var stackErr errors.StackTrace
+	for err != nil {
+		if tracer, ok := err.(pkgErrorStackTracer); ok {
+			stackErr = tracer.StackTrace()
+		}
+		cause, ok := err.(causer)
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	if stackErr == nil {
+		return nil
+	}
+
+	stFrames := []errors.Frame(stackErr)
+	frames := make([]Frame, 0, len(stFrames))
+	for i := len(stFrames) - 1; i >= 0; i-- {
+		pc := uintptr(stFrames[i])
+		fn := runtime.FuncForPC(pc)
+		if fn == nil {
+			continue
+		}
+		file, line := fn.FileLine(pc)
+		frames = append(frames, Frame{
+			Function: fn.Name(),
+			File:     file,
+			Line:     line,
+		})
+	}
+	return frames
+}
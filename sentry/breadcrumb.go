@@ -0,0 +1,210 @@
+package sentry
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/sirupsen/logrus"
+)
+
+// BreadcrumbConfiguration controls whether log entries below the hook's
+// capture level are kept around as breadcrumbs and attached to the next
+// event that is actually captured.
+type BreadcrumbConfiguration struct {
+	// whether breadcrumb buffering should be enabled
+	Enable bool
+	// the lowest level an entry must be at to be recorded as a breadcrumb
+	Level logrus.Level
+	// the maximum number of breadcrumbs to retain; oldest breadcrumbs are
+	// dropped first once the limit is reached
+	MaxBreadcrumbs int
+	// the maximum number of distinct request_id groups to buffer at once;
+	// once exceeded, the least recently touched group is evicted. Zero
+	// means a default of 1024. This bounds memory for long-running
+	// services that see many distinct request_ids, most of which never
+	// reach a captured event to drain their buffer.
+	MaxGroups int
+}
+
+const defaultMaxBreadcrumbGroups = 1024
+
+// breadcrumbBuffer is a bounded, drop-oldest ring buffer of breadcrumbs.
+type breadcrumbBuffer struct {
+	mu    sync.Mutex
+	items []sentry.Breadcrumb
+	max   int
+}
+
+func newBreadcrumbBuffer(max int) *breadcrumbBuffer {
+	return &breadcrumbBuffer{max: max}
+}
+
+func (b *breadcrumbBuffer) push(crumb sentry.Breadcrumb) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.items = append(b.items, crumb)
+	if over := len(b.items) - b.max; over > 0 {
+		b.items = b.items[over:]
+	}
+}
+
+// drain returns the buffered breadcrumbs and clears the buffer. The result
+// is []*sentry.Breadcrumb, matching sentry.Event.Breadcrumbs, so callers can
+// assign it directly.
+func (b *breadcrumbBuffer) drain() []*sentry.Breadcrumb {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.items) == 0 {
+		return nil
+	}
+	crumbs := make([]*sentry.Breadcrumb, len(b.items))
+	for i := range b.items {
+		crumb := b.items[i]
+		crumbs[i] = &crumb
+	}
+	b.items = nil
+	return crumbs
+}
+
+func (b *breadcrumbBuffer) clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.items = nil
+}
+
+// breadcrumbKey returns the key used to group an entry's breadcrumbs with
+// the packets that should receive them. Entries sharing a "request_id"
+// field are grouped together; everything else falls back to a single,
+// hook-wide buffer.
+const breadcrumbGroupField = "request_id"
+
+func breadcrumbKey(entry *logrus.Entry) string {
+	if id, ok := entry.Data[breadcrumbGroupField]; ok {
+		if s, ok := id.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// breadcrumbGroupEntry is the value stored in a breadcrumbGroups.order list
+// element, pairing a buffer with the key it's filed under so an evicted
+// element can remove itself from the lookup map.
+type breadcrumbGroupEntry struct {
+	key string
+	buf *breadcrumbBuffer
+}
+
+// breadcrumbGroups is an LRU-bounded set of per-key breadcrumb buffers. Once
+// more than capacity distinct keys are seen, the least recently touched
+// group is evicted, so a long-running service tagging many distinct
+// request_ids doesn't grow this map without bound.
+type breadcrumbGroups struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	groups   map[string]*list.Element
+}
+
+func newBreadcrumbGroups(capacity int) *breadcrumbGroups {
+	if capacity <= 0 {
+		capacity = defaultMaxBreadcrumbGroups
+	}
+	return &breadcrumbGroups{capacity: capacity, order: list.New(), groups: make(map[string]*list.Element)}
+}
+
+func (g *breadcrumbGroups) bufferFor(key string, maxBreadcrumbs int) *breadcrumbBuffer {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if elem, ok := g.groups[key]; ok {
+		g.order.MoveToFront(elem)
+		return elem.Value.(*breadcrumbGroupEntry).buf
+	}
+
+	if g.order.Len() >= g.capacity {
+		if oldest := g.order.Back(); oldest != nil {
+			g.order.Remove(oldest)
+			delete(g.groups, oldest.Value.(*breadcrumbGroupEntry).key)
+		}
+	}
+
+	buf := newBreadcrumbBuffer(maxBreadcrumbs)
+	elem := g.order.PushFront(&breadcrumbGroupEntry{key: key, buf: buf})
+	g.groups[key] = elem
+	return buf
+}
+
+func (g *breadcrumbGroups) clear() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, elem := range g.groups {
+		elem.Value.(*breadcrumbGroupEntry).buf.clear()
+	}
+}
+
+func (hook *Hook) breadcrumbBufferFor(key string) *breadcrumbBuffer {
+	hook.breadcrumbsMu.Lock()
+	if hook.breadcrumbGroups == nil {
+		hook.breadcrumbGroups = newBreadcrumbGroups(hook.BreadcrumbConfiguration.MaxGroups)
+	}
+	groups := hook.breadcrumbGroups
+	hook.breadcrumbsMu.Unlock()
+
+	return groups.bufferFor(key, hook.BreadcrumbConfiguration.MaxBreadcrumbs)
+}
+
+// recordBreadcrumb stores entry as a breadcrumb, unless BeforeBreadcrumb
+// drops it. It does not check levels itself: Fire calls it only for entries
+// below the fire threshold but at or above BreadcrumbConfiguration.Level.
+func (hook *Hook) recordBreadcrumb(entry *logrus.Entry) {
+	crumb := sentry.Breadcrumb{
+		Timestamp: entry.Time,
+		Level:     severityMap[entry.Level],
+		Category:  "log",
+		Message:   entry.Message,
+	}
+	if category, ok := entry.Data["category"].(string); ok {
+		crumb.Category = category
+	}
+
+	data := make(map[string]interface{}, len(entry.Data))
+	for k, v := range entry.Data {
+		if k == "category" {
+			continue
+		}
+		data[k] = v
+	}
+	if len(data) > 0 {
+		crumb.Data = data
+	}
+
+	crumbPtr, ok := hook.applyBeforeBreadcrumb(&crumb, entry)
+	if !ok {
+		return
+	}
+
+	hook.breadcrumbBufferFor(breadcrumbKey(entry)).push(*crumbPtr)
+}
+
+// drainBreadcrumbs returns and clears the breadcrumbs recorded for entry's
+// group, ready to be attached to a captured event.
+func (hook *Hook) drainBreadcrumbs(entry *logrus.Entry) []*sentry.Breadcrumb {
+	return hook.breadcrumbBufferFor(breadcrumbKey(entry)).drain()
+}
+
+// ClearBreadcrumbs discards all buffered breadcrumbs without attaching them
+// to an event.
+func (hook *Hook) ClearBreadcrumbs() {
+	hook.breadcrumbsMu.Lock()
+	groups := hook.breadcrumbGroups
+	hook.breadcrumbsMu.Unlock()
+
+	if groups != nil {
+		groups.clear()
+	}
+}
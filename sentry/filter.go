@@ -0,0 +1,142 @@
+package sentry
+
+import (
+	"container/list"
+	"math/rand"
+	"sync"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Breadcrumb is an alias of sentry.Breadcrumb, exported so that
+// BeforeBreadcrumb callbacks don't need to import sentry-go themselves.
+type Breadcrumb = sentry.Breadcrumb
+
+// keyLimiter caps delivery to at most limit events per tracked key, evicting
+// the least recently used key once it grows past capacity. Counts have no
+// time window: they accumulate until reset is called or the key is evicted.
+// It exists to stop a hot error loop from flooding Sentry with effectively
+// duplicate events.
+type keyLimiter struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	counts   map[string]*list.Element
+}
+
+type keyCount struct {
+	key   string
+	count int
+}
+
+func newKeyLimiter(capacity int) *keyLimiter {
+	return &keyLimiter{
+		capacity: capacity,
+		order:    list.New(),
+		counts:   make(map[string]*list.Element),
+	}
+}
+
+// allow reports whether an event for key should still be delivered, and
+// records the attempt. Once a key has been seen limit times, subsequent
+// events for that key are suppressed until ResetEventKeyCounts is called.
+func (l *keyLimiter) allow(key string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.counts[key]; ok {
+		l.order.MoveToFront(elem)
+		kc := elem.Value.(*keyCount)
+		kc.count++
+		return kc.count <= limit
+	}
+
+	if l.order.Len() >= l.capacity {
+		oldest := l.order.Back()
+		if oldest != nil {
+			l.order.Remove(oldest)
+			delete(l.counts, oldest.Value.(*keyCount).key)
+		}
+	}
+
+	elem := l.order.PushFront(&keyCount{key: key, count: 1})
+	l.counts[key] = elem
+	return limit >= 1
+}
+
+func (l *keyLimiter) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.order.Init()
+	l.counts = make(map[string]*list.Element)
+}
+
+// shouldSample reports whether an event should be kept, given hook.SampleRate.
+// A rate of zero means "no sampling configured" and always keeps the event.
+func (hook *Hook) shouldSample() bool {
+	if hook.SampleRate <= 0 {
+		return true
+	}
+	if hook.SampleRate >= 1 {
+		return true
+	}
+
+	// *rand.Rand is not safe for concurrent use, and Fire is explicitly
+	// meant to run concurrently (see the comment on hook.mu), so every
+	// access needs its own lock rather than relying on hook.mu.
+	hook.sampleRandMu.Lock()
+	defer hook.sampleRandMu.Unlock()
+	return hook.sampleRand.Float64() < hook.SampleRate
+}
+
+// ResetEventKeyCounts clears the per-key delivery counts used by
+// EventKeyFunc/MaxEventsPerKey, allowing a previously rate-limited key to be
+// delivered again.
+func (hook *Hook) ResetEventKeyCounts() {
+	if hook.keyLimiter != nil {
+		hook.keyLimiter.reset()
+	}
+}
+
+// applyBeforeBreadcrumb runs hook.BeforeBreadcrumb, if set, returning false
+// when the callback suppresses the breadcrumb.
+func (hook *Hook) applyBeforeBreadcrumb(crumb *Breadcrumb, entry *logrus.Entry) (*Breadcrumb, bool) {
+	if hook.BeforeBreadcrumb == nil {
+		return crumb, true
+	}
+	crumb = hook.BeforeBreadcrumb(crumb, entry)
+	return crumb, crumb != nil
+}
+
+// applyBeforeSend runs hook.BeforeSend, hook.SampleRate and hook.EventKeyFunc
+// against event, in that order, reporting false when event should not be
+// delivered.
+func (hook *Hook) applyBeforeSend(event *sentry.Event, entry *logrus.Entry) (*sentry.Event, bool) {
+	if hook.BeforeSend != nil {
+		event = hook.BeforeSend(event, entry)
+		if event == nil {
+			return nil, false
+		}
+	}
+
+	if !hook.shouldSample() {
+		return nil, false
+	}
+
+	if hook.EventKeyFunc != nil && hook.EventKeyLimit > 0 {
+		key := hook.EventKeyFunc(entry)
+		if key != "" && !hook.keyLimiter.allow(key, hook.EventKeyLimit) {
+			return nil, false
+		}
+	}
+
+	return event, true
+}
+
+// newSampleRand returns a PRNG seeded independently of the global source,
+// so that SampleRate decisions across multiple hooks don't share state.
+func newSampleRand() *rand.Rand {
+	return rand.New(rand.NewSource(rand.Int63()))
+}
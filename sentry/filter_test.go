@@ -0,0 +1,157 @@
+package sentry
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/sirupsen/logrus"
+)
+
+// recordingTransport stands in for sentry-go's real HTTP transport so tests
+// can observe whether an event actually left the hook, without a network.
+type recordingTransport struct {
+	mu     sync.Mutex
+	events []*sentry.Event
+}
+
+func (t *recordingTransport) Configure(options sentry.ClientOptions) {}
+
+func (t *recordingTransport) SendEvent(event *sentry.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, event)
+}
+
+func (t *recordingTransport) Flush(timeout time.Duration) bool { return true }
+
+func (t *recordingTransport) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.events)
+}
+
+func newTestHook(t *testing.T, async bool) (*Hook, *recordingTransport) {
+	t.Helper()
+
+	transport := &recordingTransport{}
+	client, err := sentry.NewClient(sentry.ClientOptions{Transport: transport})
+	if err != nil {
+		t.Fatalf("sentry.NewClient: %v", err)
+	}
+
+	hook, err := NewWithClientHook(client, []logrus.Level{logrus.ErrorLevel})
+	if err != nil {
+		t.Fatalf("NewWithClientHook: %v", err)
+	}
+	hook.Timeout = 0
+	if async {
+		hook = setAsync(hook)
+	}
+	return hook, transport
+}
+
+func errorEntry() *logrus.Entry {
+	entry := logrus.NewEntry(logrus.New())
+	entry.Level = logrus.ErrorLevel
+	return entry
+}
+
+func TestApplyBeforeSendSuppressesEvent(t *testing.T) {
+	hook, _ := newTestHook(t, false)
+	hook.BeforeSend = func(event *sentry.Event, entry *logrus.Entry) *sentry.Event { return nil }
+
+	if _, ok := hook.applyBeforeSend(sentry.NewEvent(), errorEntry()); ok {
+		t.Fatal("applyBeforeSend should suppress the event when BeforeSend returns nil")
+	}
+}
+
+// TestFireSuppressedEventNeverReachesTransportSync pins down the baseline
+// behaviour (synchronous hooks) that the asynchronous test below relies on.
+func TestFireSuppressedEventNeverReachesTransportSync(t *testing.T) {
+	hook, transport := newTestHook(t, false)
+	hook.BeforeSend = func(event *sentry.Event, entry *logrus.Entry) *sentry.Event { return nil }
+
+	if err := hook.Fire(errorEntry()); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+	if count := transport.count(); count != 0 {
+		t.Fatalf("expected a filtered event to never reach the transport, got %d sends", count)
+	}
+}
+
+// TestFireSuppressedEventNeverSpawnsAsyncDelivery verifies that BeforeSend
+// runs inside Fire itself, before an asynchronous hook would spawn its
+// delivery goroutine (sampling and the event key limiter run in the same
+// applyBeforeSend call), and that the suppressed event never reaches the
+// transport.
+func TestFireSuppressedEventNeverSpawnsAsyncDelivery(t *testing.T) {
+	hook, transport := newTestHook(t, true)
+	beforeSendCalled := false
+	hook.BeforeSend = func(event *sentry.Event, entry *logrus.Entry) *sentry.Event {
+		beforeSendCalled = true
+		return nil
+	}
+
+	if err := hook.Fire(errorEntry()); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+	if !beforeSendCalled {
+		t.Fatal("BeforeSend must run before Fire returns, not in the async delivery goroutine")
+	}
+	hook.Flush()
+
+	if count := transport.count(); count != 0 {
+		t.Fatalf("filtering must happen before the async delivery goroutine is spawned, got %d sends", count)
+	}
+}
+
+// TestFireAllowedEventReachesTransportAsynchronously is the positive
+// counterpart: an event that passes every filter still reaches the
+// transport once the async delivery goroutine completes.
+func TestFireAllowedEventReachesTransportAsynchronously(t *testing.T) {
+	hook, transport := newTestHook(t, true)
+
+	if err := hook.Fire(errorEntry()); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+	hook.Flush()
+
+	if count := transport.count(); count != 1 {
+		t.Fatalf("expected exactly one delivered event, got %d", count)
+	}
+}
+
+func TestShouldSampleZeroRateAlwaysKeeps(t *testing.T) {
+	hook, _ := newTestHook(t, false)
+	for i := 0; i < 20; i++ {
+		if !hook.shouldSample() {
+			t.Fatal("SampleRate of zero should never drop an event")
+		}
+	}
+}
+
+func TestEventKeyLimiterSuppressesPastLimit(t *testing.T) {
+	hook, transport := newTestHook(t, false)
+	hook.EventKeyLimit = 2
+	hook.EventKeyFunc = func(entry *logrus.Entry) string { return "same-key" }
+
+	for i := 0; i < 5; i++ {
+		if err := hook.Fire(errorEntry()); err != nil {
+			t.Fatalf("Fire: %v", err)
+		}
+	}
+
+	if count := transport.count(); count != 2 {
+		t.Fatalf("expected EventKeyLimit to cap delivery at 2, got %d", count)
+	}
+
+	hook.ResetEventKeyCounts()
+	if err := hook.Fire(errorEntry()); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+	if count := transport.count(); count != 3 {
+		t.Fatalf("expected ResetEventKeyCounts to allow delivery again, got %d", count)
+	}
+}
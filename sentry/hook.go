@@ -3,23 +3,25 @@ package sentry
 import (
 	"encoding/json"
 	"fmt"
-	"runtime"
+	"math/rand"
 	"sync"
 	"time"
 
-	"github.com/getsentry/raven-go"
+	"github.com/getsentry/sentry-go"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+
+	"github.com/CIP-NL/logrus-hooks/errortracker"
 )
 
 var (
-	severityMap = map[logrus.Level]raven.Severity{
-		logrus.DebugLevel: raven.DEBUG,
-		logrus.InfoLevel:  raven.INFO,
-		logrus.WarnLevel:  raven.WARNING,
-		logrus.ErrorLevel: raven.ERROR,
-		logrus.FatalLevel: raven.FATAL,
-		logrus.PanicLevel: raven.FATAL,
+	severityMap = map[logrus.Level]sentry.Level{
+		logrus.DebugLevel: sentry.LevelDebug,
+		logrus.InfoLevel:  sentry.LevelInfo,
+		logrus.WarnLevel:  sentry.LevelWarning,
+		logrus.ErrorLevel: sentry.LevelError,
+		logrus.FatalLevel: sentry.LevelFatal,
+		logrus.PanicLevel: sentry.LevelFatal,
 	}
 )
 
@@ -31,16 +33,44 @@ type Hook struct {
 	//
 	// This is ignored for asynchronous hooks. If you want to set a timeout when
 	// using an async hook (to bound the length of time that hook.Flush can take),
-	// you probably want to create your own raven.Client and set
-	// ravenClient.Transport.(*raven.HTTPTransport).Client.Timeout to set a
-	// timeout on the underlying HTTP request instead.
-	Timeout                 time.Duration
-	StacktraceConfiguration StackTraceConfiguration
-
-	client *raven.Client
+	// you probably want to configure sentry.ClientOptions.HTTPTransport/HTTPClient
+	// with your own timeout instead.
+	Timeout                  time.Duration
+	StacktraceConfiguration  StackTraceConfiguration
+	BreadcrumbConfiguration  BreadcrumbConfiguration
+	TransactionConfiguration TransactionConfiguration
+
+	// BeforeSend, if set, runs on every event immediately before delivery.
+	// Returning nil suppresses the event entirely.
+	BeforeSend func(*sentry.Event, *logrus.Entry) *sentry.Event
+	// BeforeBreadcrumb, if set, runs on every breadcrumb before it is added
+	// to the buffer. Returning nil drops the breadcrumb.
+	BeforeBreadcrumb func(*Breadcrumb, *logrus.Entry) *Breadcrumb
+	// SampleRate is the fraction of events, between 0.0 and 1.0, that are
+	// kept after BeforeSend runs. Zero (the default) disables sampling.
+	SampleRate float64
+	// EventKeyFunc, if set, derives a dedup key from an entry. At most
+	// EventKeyLimit events sharing a key are delivered before the rest are
+	// suppressed; see ResetEventKeyCounts.
+	EventKeyFunc func(*logrus.Entry) string
+	// EventKeyLimit is the number of events sharing an EventKeyFunc key
+	// that are delivered before further ones are suppressed.
+	EventKeyLimit int
+
+	sampleRandMu sync.Mutex
+	sampleRand   *rand.Rand
+	keyLimiter   *keyLimiter
+
+	client *sentry.Client
+	hub    *sentry.Hub
 	levels []logrus.Level
 
+	breadcrumbsMu    sync.Mutex
+	breadcrumbGroups *breadcrumbGroups
+
 	serverName   string
+	release      string
+	environment  string
 	ignoreFields map[string]struct{}
 	extraFilters map[string]func(interface{}) interface{}
 
@@ -50,19 +80,6 @@ type Hook struct {
 	wg sync.WaitGroup
 }
 
-// The Stacktracer interface allows an error type to return a raven.Stacktrace.
-type Stacktracer interface {
-	GetStacktrace() *raven.Stacktrace
-}
-
-type causer interface {
-	Cause() error
-}
-
-type pkgErrorStackTracer interface {
-	StackTrace() errors.StackTrace
-}
-
 // StackTraceConfiguration allows for configuring stacktraces
 type StackTraceConfiguration struct {
 	// whether stacktraces should be enabled
@@ -107,10 +124,10 @@ func (hook *Hook) Verify(dsn string) bool {
 }
 
 // NewHook creates a hook to be added to an instance of logger
-// and initializes the raven client.
+// and initializes the sentry-go client.
 // This method sets the timeout to 100 milliseconds.
 func NewHook(DSN string, levels []logrus.Level) (*Hook, error) {
-	client, err := raven.New(DSN)
+	client, err := sentry.NewClient(sentry.ClientOptions{Dsn: DSN})
 	if err != nil {
 		return nil, err
 	}
@@ -118,19 +135,24 @@ func NewHook(DSN string, levels []logrus.Level) (*Hook, error) {
 }
 
 // NewWithTagsHook creates a hook with tags to be added to an instance
-// of logger and initializes the raven client. This method sets the timeout to
-// 100 milliseconds.
+// of logger and initializes the sentry-go client. This method sets the
+// timeout to 100 milliseconds.
 func NewWithTagsHook(DSN string, tags map[string]string, levels []logrus.Level) (*Hook, error) {
-	client, err := raven.NewWithTags(DSN, tags)
+	client, err := sentry.NewClient(sentry.ClientOptions{Dsn: DSN})
 	if err != nil {
 		return nil, err
 	}
-	return NewWithClientHook(client, levels)
+	hook, err := NewWithClientHook(client, levels)
+	if err != nil {
+		return nil, err
+	}
+	hook.hub.Scope().SetTags(tags)
+	return hook, nil
 }
 
-// NewWithClientHook creates a hook using an initialized raven client.
+// NewWithClientHook creates a hook using an initialized sentry-go client.
 // This method sets the timeout to 100 milliseconds.
-func NewWithClientHook(client *raven.Client, levels []logrus.Level) (*Hook, error) {
+func NewWithClientHook(client *sentry.Client, levels []logrus.Level) (*Hook, error) {
 	return &Hook{
 		Timeout: 100 * time.Millisecond,
 		StacktraceConfiguration: StackTraceConfiguration{
@@ -141,10 +163,23 @@ func NewWithClientHook(client *raven.Client, levels []logrus.Level) (*Hook, erro
 			InAppPrefixes:     nil,
 			SendExceptionType: true,
 		},
+		BreadcrumbConfiguration: BreadcrumbConfiguration{
+			Enable:         false,
+			Level:          logrus.DebugLevel,
+			MaxBreadcrumbs: 30,
+		},
+		TransactionConfiguration: TransactionConfiguration{
+			Enable:         false,
+			DurationField:  "duration",
+			OperationField: "operation",
+		},
 		client:       client,
+		hub:          sentry.NewHub(client, sentry.NewScope()),
 		levels:       levels,
 		ignoreFields: make(map[string]struct{}),
 		extraFilters: make(map[string]func(interface{}) interface{}),
+		sampleRand:   newSampleRand(),
+		keyLimiter:   newKeyLimiter(1024),
 	}, nil
 }
 
@@ -164,7 +199,7 @@ func NewAsyncWithTagsHook(DSN string, tags map[string]string, levels []logrus.Le
 
 // NewAsyncWithClientHook creates a hook same as NewWithClientHook,
 // but in asynchronous mode.
-func NewAsyncWithClientHook(client *raven.Client, levels []logrus.Level) (*Hook, error) {
+func NewAsyncWithClientHook(client *sentry.Client, levels []logrus.Level) (*Hook, error) {
 	hook, err := NewWithClientHook(client, levels)
 	return setAsync(hook), err
 }
@@ -180,114 +215,226 @@ func setAsync(hook *Hook) *Hook {
 // Fire is called when an event should be sent to sentry
 // Special fields that sentry uses to give more information to the server
 // are extracted from entry.Data (if they are found)
-// These fields are: error, logger, server_name, http_request, tags
+// These fields are: error, logger, server_name, http_request, tags, user,
+// fingerprint, event_id
 func (hook *Hook) Fire(entry *logrus.Entry) error {
 	hook.mu.RLock() // Allow multiple go routines to log simultaneously
 	defer hook.mu.RUnlock()
-	packet := raven.NewPacket(entry.Message)
-	packet.Timestamp = raven.Timestamp(entry.Time)
-	packet.Level = severityMap[entry.Level]
-	packet.Platform = "go"
 
-	df := newDataField(entry.Data)
+	// Transactions are gated on their own configuration, not on the hook's
+	// fire levels, so a duration-tagged entry below the normal capture
+	// threshold (e.g. an Info-level request log) must still reach
+	// buildTransactionEvent even when breadcrumb buffering would otherwise
+	// swallow it below.
+	if txEvent, ok := hook.buildTransactionEvent(entry); ok {
+		// Transactions go through the same BeforeSend/SampleRate/EventKeyFunc
+		// filters as regular events; nothing exempts them.
+		if txEvent, ok := hook.applyBeforeSend(txEvent, entry); ok {
+			hook.deliver(txEvent)
+		}
+		if entry.Level > logrus.ErrorLevel {
+			// A duration was reported but the entry isn't itself an error;
+			// the transaction is all we need to send.
+			return nil
+		}
+	}
+
+	if hook.BreadcrumbConfiguration.Enable && !hook.shouldCapture(entry) {
+		if hook.isBreadcrumbCandidate(entry) {
+			hook.recordBreadcrumb(entry)
+		}
+		return nil
+	}
+
+	event := hook.buildEvent(entry)
+
+	event, ok := hook.applyBeforeSend(event, entry)
+	if !ok {
+		return nil
+	}
+
+	return hook.deliver(event)
+}
+
+// deliver hands event to the hub, respecting hook.asynchronous and
+// hook.Timeout.
+func (hook *Hook) deliver(event *sentry.Event) error {
+	if hook.asynchronous {
+		// Our use of hook.mu guarantees that we are following the WaitGroup rule of
+		// not calling Add in parallel with Wait.
+		hook.wg.Add(1)
+		go func() {
+			defer hook.wg.Done()
+			hook.hub.CaptureEvent(event)
+			if timeout := hook.Timeout; timeout > 0 {
+				hook.client.Flush(timeout)
+			}
+		}()
+		return nil
+	}
+
+	hook.hub.CaptureEvent(event)
+
+	if timeout := hook.Timeout; timeout == 0 {
+		return nil
+	} else if !hook.client.Flush(timeout) {
+		return fmt.Errorf("no response from sentry server in %s", timeout)
+	}
+	return nil
+}
+
+// buildEvent extracts the fields common to exception trackers via
+// errortracker.BuildEvent (the same extraction airbrake's backend uses),
+// then layers sentry-specific concerns (user/stacktrace shape, release,
+// breadcrumbs) on top.
+func (hook *Hook) buildEvent(entry *logrus.Entry) *sentry.Event {
+	genericEvent := errortracker.BuildEvent(entry, errortracker.StacktraceConfiguration{
+		Enable: hook.StacktraceConfiguration.Enable,
+		Level:  hook.StacktraceConfiguration.Level,
+	}, hook.ignoreFields, hook.extraFilters)
+
+	event := sentry.NewEvent()
+	event.Message = entry.Message
+	event.Timestamp = entry.Time
+	event.Level = severityMap[entry.Level]
+	event.Platform = "go"
+	event.Tags = genericEvent.Tags
+	event.Fingerprint = genericEvent.Fingerprint
+	event.Logger = genericEvent.Logger
 
 	// set special fields
 	if hook.serverName != "" {
-		packet.ServerName = hook.serverName
+		event.ServerName = hook.serverName
 	}
-	if logger, ok := df.getLogger(); ok {
-		packet.Logger = logger
+	if genericEvent.ServerName != "" {
+		event.ServerName = genericEvent.ServerName
 	}
-	if serverName, ok := df.getServerName(); ok {
-		packet.ServerName = serverName
+	if hook.release != "" {
+		event.Release = hook.release
 	}
-	if eventID, ok := df.getEventID(); ok {
-		packet.EventID = eventID
+	if hook.environment != "" {
+		event.Environment = hook.environment
 	}
-	if tags, ok := df.getTags(); ok {
-		packet.Tags = tags
+	if genericEvent.EventID != "" {
+		event.EventID = sentry.EventID(genericEvent.EventID)
 	}
-	if fingerprint, ok := df.getFingerprint(); ok {
-		packet.Fingerprint = fingerprint
+	if genericEvent.Request != nil {
+		event.Request = sentry.NewRequest(genericEvent.Request)
 	}
-	if req, ok := df.getHTTPRequest(); ok {
-		packet.Interfaces = append(packet.Interfaces, req)
-	}
-	if user, ok := df.getUser(); ok {
-		packet.Interfaces = append(packet.Interfaces, user)
+	if user, ok := userFromEntry(entry); ok {
+		event.User = user
+	} else if genericEvent.User != nil {
+		event.User = userFromMap(genericEvent.User)
 	}
 
-	// set stacktrace data
-	stConfig := &hook.StacktraceConfiguration
-	if stConfig.Enable && entry.Level <= stConfig.Level {
-		if err, ok := df.getError(); ok {
-			var currentStacktrace *raven.Stacktrace
-			currentStacktrace = hook.findStacktrace(err)
-			if currentStacktrace == nil {
-				currentStacktrace = raven.NewStacktrace(stConfig.Skip, stConfig.Context, stConfig.InAppPrefixes)
-			}
-			cause := errors.Cause(err)
+	if genericEvent.Err != nil {
+		// sentry-go has no Event.Culprit field (that's a raven-go/legacy
+		// concept); sentry derives the culprit client-side from the
+		// exception we attach below.
+		stConfig := &hook.StacktraceConfiguration
+		if stConfig.Enable && entry.Level <= stConfig.Level {
+			cause := errors.Cause(genericEvent.Err)
 			if cause == nil {
-				cause = err
+				cause = genericEvent.Err
 			}
-			exc := raven.NewException(cause, currentStacktrace)
-			if !stConfig.SendExceptionType {
-				exc.Type = ""
+			// errortracker only populates Stacktrace when it finds a
+			// github.com/pkg/errors trace on the cause chain; fall back to
+			// the stack at the point the error was logged so the exception
+			// always carries one.
+			stacktrace := sentry.NewStacktrace()
+			if len(genericEvent.Stacktrace) > 0 {
+				stacktrace = &sentry.Stacktrace{Frames: convertFrames(genericEvent.Stacktrace, stConfig.InAppPrefixes)}
 			}
-			if stConfig.SwitchExceptionTypeAndMessage {
-				packet.Interfaces = append(packet.Interfaces, currentStacktrace)
-				packet.Culprit = exc.Type + ": " + currentStacktrace.Culprit()
-			} else {
-				packet.Interfaces = append(packet.Interfaces, exc)
-				packet.Culprit = err.Error()
+			exception := sentry.Exception{
+				Value:      cause.Error(),
+				Stacktrace: stacktrace,
 			}
-		} else {
-			currentStacktrace := raven.NewStacktrace(stConfig.Skip, stConfig.Context, stConfig.InAppPrefixes)
-			if currentStacktrace != nil {
-				packet.Interfaces = append(packet.Interfaces, currentStacktrace)
+			if stConfig.SendExceptionType {
+				exception.Type = fmt.Sprintf("%T", cause)
 			}
-		}
-	} else {
-		// set the culprit even when the stack trace is disabled, as long as we have an error
-		if err, ok := df.getError(); ok {
-			packet.Culprit = err.Error()
+			if stConfig.SwitchExceptionTypeAndMessage {
+				exception.Type, exception.Value = exception.Value, exception.Type
+			}
+			event.Exception = append(event.Exception, exception)
 		}
 	}
 
-	// set other fields
-	dataExtra := hook.formatExtraData(df)
-	if packet.Extra == nil {
-		packet.Extra = dataExtra
-	} else {
-		for k, v := range dataExtra {
-			packet.Extra[k] = v
+	// errortracker.BuildEvent already ran any custom hook.extraFilters;
+	// apply sentry's default extra-data formatting to everything else.
+	event.Extra = make(map[string]interface{}, len(genericEvent.Extras))
+	for k, v := range genericEvent.Extras {
+		if _, ok := hook.extraFilters[k]; !ok {
+			v = formatData(v)
 		}
+		event.Extra[k] = v
 	}
 
-	_, errCh := hook.client.Capture(packet, nil)
+	if hook.BreadcrumbConfiguration.Enable {
+		event.Breadcrumbs = hook.drainBreadcrumbs(entry)
+	}
 
-	if hook.asynchronous {
-		// Our use of hook.mu guarantees that we are following the WaitGroup rule of
-		// not calling Add in parallel with Wait.
-		hook.wg.Add(1)
-		go func() {
-			if err := <-errCh; err != nil {
-				fmt.Println(err)
-			}
-			hook.wg.Done()
-		}()
-		return nil
-	} else if timeout := hook.Timeout; timeout == 0 {
-		return nil
-	} else {
-		timeoutCh := time.After(timeout)
-		select {
-		case err := <-errCh:
-			return err
-		case <-timeoutCh:
-			return fmt.Errorf("no response from sentry server in %s", timeout)
+	return event
+}
+
+// userFromEntry reports the "user" field directly as a sentry.User/*sentry.User,
+// for callers that already build one against the sentry-go API instead of the
+// plain map[string]interface{} that errortracker.DataField.User understands.
+func userFromEntry(entry *logrus.Entry) (sentry.User, bool) {
+	switch user := entry.Data["user"].(type) {
+	case sentry.User:
+		return user, true
+	case *sentry.User:
+		if user == nil {
+			return sentry.User{}, false
+		}
+		return *user, true
+	default:
+		return sentry.User{}, false
+	}
+}
+
+// userFromMap translates the generic user map extracted by errortracker
+// into a sentry.User, recognizing the conventional identity keys and
+// keeping anything else as arbitrary user data.
+func userFromMap(m map[string]interface{}) sentry.User {
+	user := sentry.User{}
+	data := make(map[string]string)
+	for k, v := range m {
+		s := fmt.Sprintf("%v", v)
+		switch k {
+		case "id":
+			user.ID = s
+		case "email":
+			user.Email = s
+		case "username":
+			user.Username = s
+		case "name":
+			user.Name = s
+		case "ip_address":
+			user.IPAddress = s
+		default:
+			data[k] = s
 		}
 	}
+	if len(data) > 0 {
+		user.Data = data
+	}
+	return user
+}
+
+// convertFrames converts errortracker's backend-agnostic frames into
+// sentry.Frame, applying InAppPrefixes.
+func convertFrames(frames []errortracker.Frame, inAppPrefixes []string) []sentry.Frame {
+	converted := make([]sentry.Frame, 0, len(frames))
+	for _, frame := range frames {
+		converted = append(converted, sentry.Frame{
+			Function: frame.Function,
+			Filename: frame.File,
+			Lineno:   frame.Line,
+			InApp:    isInAppFrame(frame.Function, inAppPrefixes),
+		})
+	}
+	return converted
 }
 
 // Flush waits for the log queue to empty. This function only does anything in
@@ -302,66 +449,65 @@ func (hook *Hook) Flush() {
 	hook.wg.Wait()
 }
 
-func (hook *Hook) findStacktrace(err error) *raven.Stacktrace {
-	var stacktrace *raven.Stacktrace
-	var stackErr errors.StackTrace
-	for err != nil {
-		// Find the earliest *raven.Stacktrace, or error.StackTrace
-		if tracer, ok := err.(Stacktracer); ok {
-			stacktrace = tracer.GetStacktrace()
-			stackErr = nil
-		} else if tracer, ok := err.(pkgErrorStackTracer); ok {
-			stacktrace = nil
-			stackErr = tracer.StackTrace()
-		}
-		if cause, ok := err.(causer); ok {
-			err = cause.Cause()
-		} else {
-			break
+// isInAppFrame reports whether function's package looks like it belongs to
+// the application itself, based on StackTraceConfiguration.InAppPrefixes.
+func isInAppFrame(function string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if len(function) >= len(prefix) && function[:len(prefix)] == prefix {
+			return true
 		}
 	}
-	if stackErr != nil {
-		stacktrace = hook.convertStackTrace(stackErr)
-	}
-	return stacktrace
+	return false
 }
 
-// convertStackTrace converts an errors.StackTrace into a natively consumable
-// *raven.Stacktrace
-func (hook *Hook) convertStackTrace(st errors.StackTrace) *raven.Stacktrace {
-	stConfig := &hook.StacktraceConfiguration
-	stFrames := []errors.Frame(st)
-	frames := make([]*raven.StacktraceFrame, 0, len(stFrames))
-	for i := range stFrames {
-		pc := uintptr(stFrames[i])
-		fn := runtime.FuncForPC(pc)
-		file, line := fn.FileLine(pc)
-		frame := raven.NewStacktraceFrame(pc, file, line, stConfig.Context, stConfig.InAppPrefixes)
-		if frame != nil {
-			frames = append(frames, frame)
-		}
+// Levels returns the levels logrus should dispatch to this hook. When
+// breadcrumb buffering is enabled this also includes every level down to
+// BreadcrumbConfiguration.Level, so that sub-threshold entries reach Fire
+// and can be recorded as breadcrumbs.
+func (hook *Hook) Levels() []logrus.Level {
+	if !hook.BreadcrumbConfiguration.Enable {
+		return hook.levels
 	}
 
-	// Sentry wants the frames with the oldest first, so reverse them
-	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
-		frames[i], frames[j] = frames[j], frames[i]
+	levels := make([]logrus.Level, len(hook.levels))
+	copy(levels, hook.levels)
+	for level := logrus.PanicLevel; level <= hook.BreadcrumbConfiguration.Level; level++ {
+		if !hook.shouldCaptureLevel(level) {
+			levels = append(levels, level)
+		}
 	}
-	return &raven.Stacktrace{Frames: frames}
+	return levels
 }
 
-// Levels returns the available logging levels.
-func (hook *Hook) Levels() []logrus.Level {
-	return hook.levels
+// shouldCapture reports whether entry meets the hook's own fire threshold,
+// as opposed to merely qualifying for breadcrumb buffering.
+func (hook *Hook) shouldCapture(entry *logrus.Entry) bool {
+	return hook.shouldCaptureLevel(entry.Level)
+}
+
+// isBreadcrumbCandidate reports whether entry is severe enough to be kept
+// as a breadcrumb, i.e. at or above BreadcrumbConfiguration.Level.
+func (hook *Hook) isBreadcrumbCandidate(entry *logrus.Entry) bool {
+	return entry.Level <= hook.BreadcrumbConfiguration.Level
+}
+
+func (hook *Hook) shouldCaptureLevel(level logrus.Level) bool {
+	for _, l := range hook.levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
 }
 
 // SetRelease sets release tag.
 func (hook *Hook) SetRelease(release string) {
-	hook.client.SetRelease(release)
+	hook.release = release
 }
 
 // SetEnvironment sets environment tag.
 func (hook *Hook) SetEnvironment(environment string) {
-	hook.client.SetEnvironment(environment)
+	hook.environment = environment
 }
 
 // SetServerName sets server_name tag.
@@ -379,27 +525,6 @@ func (hook *Hook) AddExtraFilter(name string, fn func(interface{}) interface{})
 	hook.extraFilters[name] = fn
 }
 
-func (hook *Hook) formatExtraData(df *dataField) (result map[string]interface{}) {
-	// create a map for passing to Sentry's extra data
-	result = make(map[string]interface{}, df.len())
-	for k, v := range df.data {
-		if df.isOmit(k) {
-			continue // skip already used special fields
-		}
-		if _, ok := hook.ignoreFields[k]; ok {
-			continue
-		}
-
-		if fn, ok := hook.extraFilters[k]; ok {
-			v = fn(v) // apply custom filter
-		} else {
-			v = formatData(v) // use default formatter
-		}
-		result[k] = v
-	}
-	return result
-}
-
 // formatData returns value as a suitable format.
 func formatData(value interface{}) (formatted interface{}) {
 	switch value := value.(type) {